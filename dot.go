@@ -0,0 +1,33 @@
+// Copyright 2013 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsched
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Write a Graphviz DOT digraph to w
+// with a node for each scheduled
+// event, labeled with its time, and
+// an edge from each event to the
+// event which would be called after
+// it. s is not modified.
+func (s *Scheduler) WriteDOT(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph fsched {\n")
+	events := s.sorted()
+	for i, evt := range events {
+		fmt.Fprintf(&buf, "\te%d [label=%s];\n", i, strconv.Quote(evt.time.String()))
+	}
+	for i := 1; i < len(events); i++ {
+		fmt.Fprintf(&buf, "\te%d -> e%d;\n", i-1, i)
+	}
+	buf.WriteString("}\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
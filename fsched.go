@@ -23,35 +23,80 @@
 package fsched
 
 import (
-	"container/heap"
+	"context"
 	"errors"
+	"sort"
 	"time"
 )
 
 type event struct {
-	f    func(time.Time) interface{}
+	// fn is the callback, which is a
+	// func(time.Time) interface{},
+	// func(time.Time) error, or
+	// func(time.Time) (interface{}, error).
+	fn interface{}
+	// If skip is non-nil and returns
+	// true when the event is reached,
+	// the callback is not called.
+	skip func() bool
 	time time.Time
+	// Events at the same time are ordered
+	// by descending priority, and then by
+	// the order in which they were scheduled.
+	seq      uint64
+	priority int
 }
 
-type eventHeap []event
-
-func (e eventHeap) Len() int            { return len(e) }
-func (e eventHeap) Less(i, j int) bool  { return e[i].time.Before(e[j].time) }
-func (e eventHeap) Swap(i, j int)       { e[i], e[j] = e[j], e[i] }
-func (e *eventHeap) Push(x interface{}) { *e = append(*e, x.(event)) }
-func (e *eventHeap) Pop() interface{} {
-	old := *e
-	n := len(old)
-	x := old[n-1]
-	*e = old[0 : n-1]
-	return x
+// call calls the event's callback,
+// returning its result and, for
+// error-returning callbacks, its error.
+func (e event) call() (interface{}, error) {
+	switch f := e.fn.(type) {
+	case func(time.Time) error:
+		return nil, f(e.time)
+	case func(time.Time) (interface{}, error):
+		return f(e.time)
+	}
+	return e.fn.(func(time.Time) interface{})(e.time), nil
 }
 
 var (
-	ErrPast  = errors.New("Event scheduled in the past")
-	ErrEmpty = errors.New("Empty")
+	ErrPast            = errors.New("Event scheduled in the past")
+	ErrEmpty           = errors.New("Empty")
+	ErrFull            = errors.New("Full")
+	ErrClosed          = errors.New("Closed")
+	ErrClockRegression = errors.New("Clock regression")
 )
 
+// EventError records an error concerning
+// a particular event, such as an error
+// returned by its callback, along with
+// the event's time.
+type EventError struct {
+	Time time.Time
+	Err  error
+}
+
+func (e *EventError) Error() string {
+	return e.Time.String() + ": " + e.Err.Error()
+}
+
+// Returns the underlying callback error.
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// Clock provides the current real
+// (wall-clock) time, as opposed to
+// a Scheduler's internal clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
 // Note that this doc comment shares text with
 // the package overview. Please keep in sync.
 
@@ -64,8 +109,17 @@ var (
 // allows scheduled callbacks to safely interact
 // with the Scheduler, for example to schedule more events.
 type Scheduler struct {
-	heap *eventHeap
-	now  time.Time
+	heap    *eventHeap
+	now     time.Time
+	start   time.Time
+	growth  int
+	compact float64
+	max     int
+	evict   EvictPolicy
+	seq     uint64
+	clock   Clock
+	closed  bool
+	fired   int
 }
 
 // Returns a new Scheduler whose
@@ -73,24 +127,357 @@ type Scheduler struct {
 // zero value of time.Time.
 func NewScheduler() *Scheduler {
 	s := Scheduler{heap: new(eventHeap)}
-	*s.heap = make([]event, 0)
+	s.heap.events = make([]event, 0)
 	return &s
 }
 
 // Returns a new Scheduler whose
 // internal clock is set to t.
 func NewSchedulerTime(t time.Time) *Scheduler {
-	s := Scheduler{new(eventHeap), t}
-	*s.heap = make([]event, 0)
+	s := Scheduler{heap: new(eventHeap), now: t, start: t}
+	s.heap.events = make([]event, 0)
 	return &s
 }
 
+// schedule adds evt to the heap,
+// returning ErrClosed if s is
+// closed, ErrPast if evt is
+// before s.Now(), or ErrFull if
+// s is bounded and full.
+func (s *Scheduler) schedule(evt event) error {
+	_, _, err := s.insert(evt)
+	return err
+}
+
+// insert is like schedule, but
+// also returns the event evicted
+// to make room for evt, if any.
+func (s *Scheduler) insert(evt event) (event, bool, error) {
+	if s.closed {
+		return event{}, false, ErrClosed
+	}
+	if evt.time.Before(s.now) {
+		return event{}, false, ErrPast
+	}
+	var evicted event
+	var ok bool
+	if s.max > 0 && s.heap.Len() >= s.max {
+		i := s.victim(evt)
+		if i < 0 {
+			return event{}, false, ErrFull
+		}
+		evicted, ok = s.heap.remove(i), true
+	}
+	s.seq++
+	evt.seq = s.seq
+	s.grow(1)
+	s.heap.push(evt)
+	return evicted, ok, nil
+}
+
+// grow makes room for n more events
+// in chunks of s's growth hint, if
+// one is set. Otherwise, room is
+// made by append as needed.
+func (s *Scheduler) grow(n int) {
+	h := s.heap.events
+	if s.growth <= 0 || len(h)+n <= cap(h) {
+		return
+	}
+	c := cap(h)
+	for c < len(h)+n {
+		c += s.growth
+	}
+	nh := make([]event, len(h), c)
+	copy(nh, h)
+	s.heap.events = nh
+}
+
+// victim returns the index of the
+// event to evict according to s's
+// EvictPolicy in order to make room
+// for evt, or -1 if evt should be
+// rejected.
+func (s *Scheduler) victim(evt event) int {
+	h := s.heap.events
+	if len(h) == 0 {
+		return -1
+	}
+	i := -1
+	switch s.evict {
+	case EvictNewest:
+		i = 0
+		for j := range h {
+			if h[j].seq > h[i].seq {
+				i = j
+			}
+		}
+	case EvictLatest:
+		// Evict the event which would be
+		// called last, unless evt would be
+		// called after it.
+		i = 0
+		for j := range h {
+			if s.heap.Less(i, j) {
+				i = j
+			}
+		}
+		evt.seq = s.seq + 1
+		if !s.heap.less(&evt, &h[i]) {
+			return -1
+		}
+	}
+	return i
+}
+
+// Grow the internal event storage
+// by n events at a time, rather than
+// doubling it, whenever it fills up.
+// This bounds over-allocation for
+// very large schedules, at the cost
+// of more frequent copying.
+//
+// If n <= 0, the default doubling
+// strategy is used.
+func (s *Scheduler) SetGrowthHint(n int) {
+	s.growth = n
+}
+
+// Reallocate the internal event
+// storage to fit the scheduled events
+// exactly whenever removing an event
+// leaves it less than threshold full.
+// This allows memory to be released
+// as a large schedule is drained.
+//
+// If threshold is not strictly between
+// 0 and 1, the storage is never
+// compacted. This is the default.
+func (s *Scheduler) SetAutoCompact(threshold float64) {
+	if threshold <= 0 || threshold >= 1 {
+		threshold = 0
+	}
+	s.compact = threshold
+}
+
+// pop removes and returns the next
+// event, compacting the event slice
+// if necessary. s must not be empty.
+func (s *Scheduler) pop() event {
+	evt := s.heap.pop()
+	h := s.heap.events
+	if s.compact > 0 && float64(len(h)) < float64(cap(h))*s.compact {
+		s.heap.events = append([]event(nil), h...)
+	}
+	return evt
+}
+
+// Set the Clock used to measure
+// real time, for example by
+// ScheduleTTL. By default, the
+// system clock is used. If c is
+// nil, the default is restored.
+func (s *Scheduler) SetClock(c Clock) {
+	s.clock = c
+}
+
+// realNow returns the current
+// time according to s's Clock.
+func (s *Scheduler) realNow() time.Time {
+	if s.clock == nil {
+		return realClock{}.Now()
+	}
+	return s.clock.Now()
+}
+
+// Returns a new Scheduler whose
+// internal clock is set to the
+// zero value of time.Time, and
+// which holds at most max events.
+// Once max events are scheduled,
+// scheduling returns ErrFull until
+// an event is fired or removed.
+//
+// If max <= 0, the Scheduler is
+// unbounded.
+func NewSchedulerBounded(max int) *Scheduler {
+	s := NewScheduler()
+	s.max = max
+	return s
+}
+
+// EvictPolicy determines what a bounded
+// Scheduler does when an event is
+// scheduled while it is full.
+type EvictPolicy int
+
+const (
+	// Reject the new event with ErrFull.
+	RejectNew EvictPolicy = iota
+	// Remove the most recently scheduled
+	// pending event to make room.
+	EvictNewest
+	// Remove the pending event which
+	// would be called last to make room.
+	// Among events at the same time, this
+	// follows the EqualTimePolicy (see
+	// SetPolicy). If the new event would
+	// be called after every pending event,
+	// it is rejected with ErrFull instead.
+	EvictLatest
+)
+
+// Returns a new Scheduler like
+// NewSchedulerBounded, but which
+// applies policy when an event is
+// scheduled while it is full.
+//
+// Use ScheduleEvict to learn which
+// event, if any, was evicted.
+func NewSchedulerBoundedEvict(max int, policy EvictPolicy) *Scheduler {
+	s := NewSchedulerBounded(max)
+	s.evict = policy
+	return s
+}
+
+// EqualTimePolicy determines the order
+// in which events scheduled for the
+// same time are called.
+type EqualTimePolicy int
+
+const (
+	// Call events in descending order of
+	// priority (see SchedulePriority), and
+	// events of equal priority in the order
+	// in which they were scheduled. This is
+	// the default.
+	Priority EqualTimePolicy = iota
+	// Call events in the order in which
+	// they were scheduled, ignoring priority.
+	FIFO
+	// Call events in the reverse of the
+	// order in which they were scheduled,
+	// ignoring priority.
+	LIFO
+)
+
+// Returns a new Scheduler whose
+// internal clock is set to the
+// zero value of time.Time, and
+// which orders events scheduled
+// for the same time according to
+// policy. See also SetPolicy.
+func NewSchedulerPolicy(policy EqualTimePolicy) *Scheduler {
+	s := NewScheduler()
+	s.SetPolicy(policy)
+	return s
+}
+
+// Order events scheduled for the
+// same time according to policy,
+// including events which are already
+// scheduled. This allows a policy to
+// be combined with the options of
+// the other constructors, such as
+// NewSchedulerTime or
+// NewSchedulerBoundedEvict.
+func (s *Scheduler) SetPolicy(policy EqualTimePolicy) {
+	s.heap.policy = policy
+	s.heap.init()
+}
+
+// advance moves the internal clock
+// forward to t. All changes to the
+// clock, other than History.Undo, go
+// through advance, which guarantees
+// that the clock never moves backward.
+// If t is before s.Now(), the clock
+// is not changed, and advance returns
+// ErrClockRegression.
+func (s *Scheduler) advance(t time.Time) error {
+	if t.Before(s.now) {
+		return ErrClockRegression
+	}
+	if t.After(s.now) {
+		s.fired = 0
+	}
+	s.now = t
+	return nil
+}
+
+// next advances the internal clock
+// to the next scheduled event, and
+// removes and returns the event. If
+// the event is before s.Now(), it
+// is left scheduled, and next returns
+// ErrClockRegression.
+func (s *Scheduler) next() (event, error) {
+	if s.Empty() {
+		return event{}, ErrEmpty
+	}
+	if err := s.advance(s.heap.events[0].time); err != nil {
+		return event{}, err
+	}
+	return s.pop(), nil
+}
+
+// fire calls evt's callback and
+// returns true and its result and
+// error, counting it towards
+// FiredAtNow. If evt is skipped,
+// fire returns false and zero
+// values instead.
+func (s *Scheduler) fire(evt event) (bool, interface{}, error) {
+	if evt.skip != nil && evt.skip() {
+		return false, nil, nil
+	}
+	s.fired++
+	v, err := evt.call()
+	return true, v, err
+}
+
+// fireValue is like fire, but
+// returns the callback's error in
+// place of its result if the error
+// is non-nil, as CallNext does.
+func (s *Scheduler) fireValue(evt event) (bool, interface{}) {
+	called, v, err := s.fire(evt)
+	if err != nil {
+		return called, err
+	}
+	return called, v
+}
+
+// Returns the number of events
+// called since the internal clock
+// last moved forward; that is, the
+// number of events called at the
+// current time. Skipped events (see
+// ScheduleCtxFunc and ScheduleTTL)
+// are not counted.
+func (s *Scheduler) FiredAtNow() int {
+	return s.fired
+}
+
 // Returns the current value
 // of the internal clock.
 func (s *Scheduler) Now() time.Time {
 	return s.now
 }
 
+// Returns whether the internal
+// clock has moved from the time
+// at which s was created.
+func (s *Scheduler) Started() bool {
+	return !s.now.Equal(s.start)
+}
+
+// Returns the number of
+// events scheduled.
+func (s *Scheduler) Len() int {
+	return s.heap.Len()
+}
+
 // Returns whether there are
 // 0 events scheduled.
 func (s *Scheduler) Empty() bool {
@@ -101,12 +488,71 @@ func (s *Scheduler) Empty() bool {
 // the internal clock reaches t.
 //
 // Returns ErrPast if t is before
-// s.Now().
+// s.Now(), ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
 func (s *Scheduler) Schedule(f func(time.Time) interface{}, t time.Time) error {
-	if t.Before(s.now) {
-		return ErrPast
+	return s.schedule(event{fn: f, time: t})
+}
+
+// Schedule f to be called when
+// the internal clock reaches t.
+// Among events scheduled for the
+// same time, those with higher
+// priority are called first, and
+// those with equal priority are
+// called in the order in which they
+// were scheduled. Events scheduled
+// with Schedule have priority 0.
+//
+// Returns ErrPast if t is before
+// s.Now(), ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
+func (s *Scheduler) SchedulePriority(f func(time.Time) interface{}, t time.Time, priority int) error {
+	return s.schedule(event{fn: f, time: t, priority: priority})
+}
+
+// Schedule f to be called when
+// the internal clock reaches each
+// of the given times. Either all
+// of the events are scheduled, or,
+// if an error is returned, none are.
+//
+// If any time is before s.Now(),
+// returns an *EventError holding
+// the first such time and ErrPast.
+// Unlike the other Schedule methods,
+// the error is not ErrPast itself,
+// so callers must use a type
+// assertion to inspect it.
+//
+// Returns ErrFull if s is bounded
+// and cannot hold all of the events.
+// No events are evicted to make
+// room, regardless of s's
+// EvictPolicy, since that could
+// evict events from the batch itself.
+//
+// Returns ErrClosed if s is closed.
+func (s *Scheduler) ScheduleTimes(f func(time.Time) interface{}, times []time.Time) error {
+	if s.closed {
+		return ErrClosed
+	}
+	for _, t := range times {
+		if t.Before(s.now) {
+			return &EventError{t, ErrPast}
+		}
+	}
+	if s.max > 0 && s.heap.Len()+len(times) > s.max {
+		return ErrFull
+	}
+	s.grow(len(times))
+	for _, t := range times {
+		s.seq++
+		s.heap.events = append(s.heap.events, event{fn: f, time: t, seq: s.seq})
 	}
-	heap.Push(s.heap, event{f, t})
+	s.heap.init()
 	return nil
 }
 
@@ -114,11 +560,185 @@ func (s *Scheduler) Schedule(f func(time.Time) interface{}, t time.Time) error {
 // offset has elapsed.
 //
 // Returns ErrPast if offset is
-// negative.
+// negative, ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
 func (s *Scheduler) ScheduleOffset(f func(time.Time) interface{}, offset time.Duration) error {
 	return s.Schedule(f, s.now.Add(offset))
 }
 
+// Schedule f to be called when
+// offset has elapsed. Unlike
+// ScheduleOffset, a negative offset
+// is treated as 0, scheduling f
+// for s.Now(). Thus, it never
+// returns ErrPast.
+//
+// Returns ErrFull if s is bounded
+// and full, or ErrClosed if s is
+// closed.
+func (s *Scheduler) ScheduleOffsetClamp(f func(time.Time) interface{}, offset time.Duration) error {
+	if offset < 0 {
+		offset = 0
+	}
+	return s.ScheduleOffset(f, offset)
+}
+
+// Schedule f to be called when
+// the internal clock reaches t.
+// If s is bounded and full, an
+// event may be evicted to make
+// room according to s's EvictPolicy.
+// If so, return the time of the
+// evicted event and true.
+//
+// Returns ErrPast if t is before
+// s.Now(), ErrFull if s is
+// bounded and full and the event
+// is rejected, or ErrClosed if s
+// is closed.
+func (s *Scheduler) ScheduleEvict(f func(time.Time) interface{}, t time.Time) (time.Time, bool, error) {
+	evt, ok, err := s.insert(event{fn: f, time: t})
+	return evt.time, ok, err
+}
+
+// Schedule f to be called when
+// the internal clock reaches t,
+// and return whether the new event
+// is now the next scheduled event.
+// This is useful for deciding
+// whether a timer waiting on the
+// next event must be reset.
+//
+// Returns ErrPast if t is before
+// s.Now(), ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
+func (s *Scheduler) ScheduleEarliest(f func(time.Time) interface{}, t time.Time) (bool, error) {
+	if err := s.Schedule(f, t); err != nil {
+		return false, err
+	}
+	return s.heap.events[0].seq == s.seq, nil
+}
+
+// Schedule f to be called when
+// the internal clock reaches t,
+// passing val as the second
+// argument. This allows per-event
+// data to be carried with the event
+// rather than captured in a closure.
+//
+// Returns ErrPast if t is before
+// s.Now(), ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
+func (s *Scheduler) ScheduleValue(f func(time.Time, interface{}) interface{}, t time.Time, val interface{}) error {
+	return s.Schedule(func(t time.Time) interface{} { return f(t, val) }, t)
+}
+
+// Schedule f to be called with s
+// when the internal clock reaches t.
+// This allows callbacks defined
+// independently of s to interact
+// with it, for example to schedule
+// more events.
+//
+// Returns ErrPast if t is before
+// s.Now(), ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
+func (s *Scheduler) ScheduleSelf(f func(s *Scheduler, t time.Time) interface{}, t time.Time) error {
+	return s.Schedule(func(t time.Time) interface{} { return f(s, t) }, t)
+}
+
+// Schedule f to be called with ctx
+// when the internal clock reaches t.
+//
+// If ctx has been canceled by the
+// time the event is reached, f is
+// not called; the event is skipped,
+// and CallNext returns a nil result.
+// The internal clock is advanced
+// as usual in either case, but a
+// skipped event is not counted as
+// called (see FiredAtNow and
+// RunUntilN).
+//
+// Returns ErrPast if t is before
+// s.Now(), ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
+func (s *Scheduler) ScheduleCtxFunc(ctx context.Context, f func(context.Context, time.Time) interface{}, t time.Time) error {
+	return s.schedule(event{
+		fn:   func(t time.Time) interface{} { return f(ctx, t) },
+		skip: func() bool { return ctx.Err() != nil },
+		time: t,
+	})
+}
+
+// Schedule f to be called when
+// the internal clock reaches t,
+// unless more than ttl of real
+// time has passed since the event
+// was scheduled.
+//
+// Real time is measured by s's
+// Clock, and is unrelated to the
+// internal clock: t is compared
+// only against the internal clock,
+// and ttl only against the Clock.
+// The ttl is checked when CallNext
+// reaches the event. If it has
+// expired, f is not called; the
+// event is discarded, and CallNext
+// returns a nil result. The internal
+// clock is advanced as usual in
+// either case, but a discarded event
+// is not counted as called.
+//
+// Returns ErrPast if t is before
+// s.Now(), ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
+func (s *Scheduler) ScheduleTTL(f func(time.Time) interface{}, t time.Time, ttl time.Duration) error {
+	start := s.realNow()
+	return s.schedule(event{
+		fn:   f,
+		skip: func() bool { return s.realNow().Sub(start) > ttl },
+		time: t,
+	})
+}
+
+// Schedule f to be called when
+// the internal clock reaches t.
+// Unlike Schedule, f reports only
+// success or failure. Errors
+// returned by f are reported
+// by RunAllE.
+//
+// Returns ErrPast if t is before
+// s.Now(), ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
+func (s *Scheduler) ScheduleE(f func(time.Time) error, t time.Time) error {
+	return s.schedule(event{fn: f, time: t})
+}
+
+// Schedule f to be called when
+// the internal clock reaches t.
+// f returns both a result and an
+// error; both are reported by StepRE.
+// Errors returned by f are also
+// reported by RunAllE.
+//
+// Returns ErrPast if t is before
+// s.Now(), ErrFull if s is
+// bounded and full, or ErrClosed
+// if s is closed.
+func (s *Scheduler) ScheduleRE(f func(time.Time) (interface{}, error), t time.Time) error {
+	return s.schedule(event{fn: f, time: t})
+}
+
 // Returns the timestamp on the next
 // scheduled event, or the zero value
 // and ErrEmpty if no events are
@@ -128,7 +748,240 @@ func (s *Scheduler) PeekNext() (time.Time, error) {
 	if s.Empty() {
 		return t, ErrEmpty
 	}
-	return (*s.heap)[0].time, nil
+	return s.heap.events[0].time, nil
+}
+
+// Returns the offset from s.Now()
+// to the next scheduled event, or
+// 0 and ErrEmpty if no events are
+// scheduled. The offset is never
+// negative.
+func (s *Scheduler) PeekNextOffset() (time.Duration, error) {
+	t, err := s.PeekNext()
+	if err != nil {
+		return 0, err
+	}
+	return t.Sub(s.now), nil
+}
+
+// Returns the timestamps of all
+// scheduled events. The timestamps
+// are NOT sorted; they are in the
+// Scheduler's internal order, except
+// that the first is always the
+// earliest. Callers which need them
+// in order must sort them.
+func (s *Scheduler) Times() []time.Time {
+	times := make([]time.Time, len(s.heap.events))
+	for i, evt := range s.heap.events {
+		times[i] = evt.time
+	}
+	return times
+}
+
+// sorted returns a copy of the
+// scheduled events in the order
+// in which they would be called.
+func (s *Scheduler) sorted() []event {
+	return s.sortedPolicy(s.heap.policy)
+}
+
+// sortedPolicy is like sorted, but
+// orders events as if s used policy.
+func (s *Scheduler) sortedPolicy(policy EqualTimePolicy) []event {
+	h := eventHeap{make([]event, len(s.heap.events)), policy}
+	copy(h.events, s.heap.events)
+	events := make([]event, len(h.events))
+	for i := range events {
+		events[i] = h.pop()
+	}
+	return events
+}
+
+// Iterator iterates over the offsets
+// of scheduled events from the time
+// at which it was created.
+type Iterator struct {
+	offsets []time.Duration
+}
+
+// Returns the next offset, and
+// whether there was one. Once the
+// Iterator is exhausted, Next
+// returns 0 and false.
+func (it *Iterator) Next() (time.Duration, bool) {
+	if len(it.offsets) == 0 {
+		return 0, false
+	}
+	d := it.offsets[0]
+	it.offsets = it.offsets[1:]
+	return d, true
+}
+
+// Returns an Iterator over the
+// offsets from s.Now() of all
+// scheduled events, in the order
+// in which they would be called.
+//
+// The Iterator operates on a
+// snapshot taken when it is
+// created, and its offsets are
+// relative to s.Now() at that
+// time. Later changes to s do
+// not affect it.
+func (s *Scheduler) OffsetIterator() *Iterator {
+	events := s.sorted()
+	offsets := make([]time.Duration, len(events))
+	for i, evt := range events {
+		offsets[i] = evt.time.Sub(s.now)
+	}
+	return &Iterator{offsets}
+}
+
+// Returns the number of scheduled
+// events per second in the window
+// [s.Now(), s.Now()+window). If
+// window is not positive, returns 0.
+func (s *Scheduler) RateInWindow(window time.Duration) float64 {
+	if window <= 0 {
+		return 0
+	}
+	end := s.now.Add(window)
+	n := 0
+	for _, evt := range s.heap.events {
+		if evt.time.Before(end) {
+			n++
+		}
+	}
+	return float64(n) / window.Seconds()
+}
+
+// Returns the number of scheduled
+// events at or before t; that is,
+// the number of events which would
+// be called in advancing the internal
+// clock to t.
+func (s *Scheduler) CountDueBy(t time.Time) int {
+	n := 0
+	for _, evt := range s.heap.events {
+		if !evt.time.After(t) {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns the median time of the
+// scheduled events, or the zero value
+// and ErrEmpty if no events are
+// scheduled. If an even number of
+// events are scheduled, the earlier
+// of the two middle times is returned.
+//
+// The median is found by selection
+// on a copy of the event times, which
+// takes linear time on average rather
+// than sorting them; s is not modified.
+func (s *Scheduler) MedianApprox() (time.Time, error) {
+	times := s.Times()
+	if len(times) == 0 {
+		return time.Time{}, ErrEmpty
+	}
+	k := (len(times) - 1) / 2
+	lo, hi := 0, len(times)-1
+	for lo < hi {
+		// Partition around the middle element.
+		pivot := times[lo+(hi-lo)/2]
+		i, j := lo, hi
+		for i <= j {
+			for times[i].Before(pivot) {
+				i++
+			}
+			for times[j].After(pivot) {
+				j--
+			}
+			if i <= j {
+				times[i], times[j] = times[j], times[i]
+				i++
+				j--
+			}
+		}
+		switch {
+		case k <= j:
+			hi = j
+		case k >= i:
+			lo = i
+		default:
+			return times[k], nil
+		}
+	}
+	return times[k], nil
+}
+
+// Divide the span from the earliest
+// to the latest scheduled event into
+// n buckets of equal width, and return
+// the number of events in each bucket
+// along with the n+1 bucket boundaries.
+// Bucket i holds events in the range
+// [bounds[i], bounds[i+1]), except that
+// the last bucket also holds events at
+// bounds[n]. If all events are at the
+// same time, they are all in the first
+// bucket.
+//
+// If no events are scheduled or n is
+// not positive, returns nil slices.
+func (s *Scheduler) Bin(n int) ([]int, []time.Time) {
+	events := s.heap.events
+	if len(events) == 0 || n <= 0 {
+		return nil, nil
+	}
+	min, max := events[0].time, events[0].time
+	for _, evt := range events[1:] {
+		if evt.time.After(max) {
+			max = evt.time
+		}
+	}
+	span := float64(max.Sub(min))
+	bounds := make([]time.Time, n+1)
+	for i := 0; i < n; i++ {
+		bounds[i] = min.Add(time.Duration(span * float64(i) / float64(n)))
+	}
+	bounds[n] = max
+	counts := make([]int, n)
+	if span == 0 {
+		counts[0] = len(events)
+		return counts, bounds
+	}
+	for _, evt := range events {
+		i := sort.Search(n-1, func(i int) bool {
+			return bounds[i+1].After(evt.time)
+		})
+		counts[i]++
+	}
+	return counts, bounds
+}
+
+// Returns the earliest timestamp of
+// any scheduled event which is strictly
+// after t, or the zero value and
+// ErrEmpty if there is no such event.
+// This requires a scan of all
+// scheduled events.
+func (s *Scheduler) NextTimeAfter(t time.Time) (time.Time, error) {
+	var next time.Time
+	found := false
+	for _, evt := range s.heap.events {
+		if evt.time.After(t) && (!found || evt.time.Before(next)) {
+			next = evt.time
+			found = true
+		}
+	}
+	if !found {
+		return next, ErrEmpty
+	}
+	return next, nil
 }
 
 // Fast-forward the internal clock
@@ -140,19 +993,235 @@ func (s *Scheduler) PeekNext() (time.Time, error) {
 //
 // If there are no events scheduled,
 // return a nil interface value and
-// ErrEmpty.
+// ErrEmpty. If the next event is
+// before s.Now(), it is not called,
+// and ErrClockRegression is returned.
+// The event is left scheduled, so
+// CallNext keeps returning
+// ErrClockRegression until it is
+// removed, for example with
+// RemoveNext or DiscardPast.
+//
+// If the event was scheduled with
+// ScheduleE or ScheduleRE and the
+// callback returns a non-nil error,
+// that error is returned as the value.
+// Use StepRE to receive the result
+// and error separately.
 //
 // Note that CallNext does not modify
 // s after calling the callback. Thus,
 // it is safe to call methods on s
 // from within the callback.
 func (s *Scheduler) CallNext() (interface{}, error) {
+	evt, err := s.next()
+	if err != nil {
+		return nil, err
+	}
+	_, v := s.fireValue(evt)
+	return v, nil
+}
+
+// If the next scheduled event's time
+// is not after the real time given
+// by clock, call it as CallNext would,
+// and return its value and true.
+// Otherwise, do nothing, and return
+// nil and false. This allows the
+// Scheduler to be polled by a loop
+// which tracks real time. If clock
+// is nil, s's Clock is used (see
+// SetClock).
+//
+// Event times are compared directly
+// against clock's time, so the
+// internal clock should be started
+// at a corresponding real time
+// (see NewSchedulerTime).
+//
+// If there are no events scheduled,
+// return nil, false, and ErrEmpty.
+// If the next event is before s.Now(),
+// return nil, false, and
+// ErrClockRegression.
+func (s *Scheduler) CallNextIfDue(clock Clock) (interface{}, bool, error) {
+	if s.Empty() {
+		return nil, false, ErrEmpty
+	}
+	now := s.realNow()
+	if clock != nil {
+		now = clock.Now()
+	}
+	if s.heap.events[0].time.After(now) {
+		return nil, false, nil
+	}
+	v, err := s.CallNext()
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+// Fast-forward the internal clock
+// to match the next scheduled event,
+// and remove the event. If pred
+// returns true for the event's time,
+// call the associated callback and
+// return its value as CallNext would,
+// and true. Otherwise, or if the
+// event is skipped (see
+// ScheduleCtxFunc and ScheduleTTL),
+// do not call the callback, and
+// return nil and false.
+//
+// If there are no events scheduled,
+// return nil, false, and ErrEmpty.
+// If the next event is before s.Now(),
+// it is neither called nor removed,
+// and ErrClockRegression is returned.
+func (s *Scheduler) CallOrDrop(pred func(t time.Time) bool) (interface{}, bool, error) {
+	evt, err := s.next()
+	if err != nil {
+		return nil, false, err
+	}
+	if !pred(evt.time) {
+		return nil, false, nil
+	}
+	called, v := s.fireValue(evt)
+	return v, called, nil
+}
+
+// Fast-forward the internal clock
+// to match the next scheduled event,
+// and call every event scheduled for
+// that time. Return the time and the
+// values returned from the callbacks,
+// as CallNext would return them, in
+// the order in which they were called.
+//
+// Events are called in the same order
+// as by successive calls to CallNext,
+// as determined by s's EqualTimePolicy.
+// By default, this is descending order
+// of priority, and then the order in
+// which they were scheduled. However,
+// events scheduled for the same time
+// by the callbacks are not called.
+//
+// If there are no events scheduled,
+// return the zero time, a nil slice,
+// and ErrEmpty. If the next event is
+// before s.Now(), no events are
+// called, and ErrClockRegression is
+// returned instead.
+func (s *Scheduler) CallAllAt() (time.Time, []interface{}, error) {
 	if s.Empty() {
-		return nil, ErrEmpty
+		return time.Time{}, nil, ErrEmpty
+	}
+	t := s.heap.events[0].time
+	if err := s.advance(t); err != nil {
+		return time.Time{}, nil, err
+	}
+	var batch []event
+	for !s.Empty() && s.heap.events[0].time.Equal(t) {
+		batch = append(batch, s.pop())
+	}
+	vals := make([]interface{}, len(batch))
+	for i, evt := range batch {
+		_, vals[i] = s.fireValue(evt)
+	}
+	return t, vals, nil
+}
+
+// Fast-forward the internal clock
+// to match the next scheduled event,
+// and call the associated callback.
+// Return the time at which the event
+// fired, the callback's result, the
+// callback's error, and finally any
+// error from the Scheduler itself.
+//
+// Events scheduled with Schedule
+// always have a nil callback error,
+// and events scheduled with ScheduleE
+// always have a nil result.
+//
+// Callback errors are returned to the
+// caller as-is; they are not wrapped
+// or handled by the Scheduler.
+//
+// If there are no events scheduled,
+// return ErrEmpty as the Scheduler
+// error, and zero values otherwise.
+// Likewise, if the next event is
+// before s.Now(), it is not called,
+// and ErrClockRegression is returned.
+func (s *Scheduler) StepRE() (time.Time, interface{}, error, error) {
+	evt, err := s.next()
+	if err != nil {
+		return time.Time{}, nil, nil, err
+	}
+	_, v, err := s.fire(evt)
+	return evt.time, v, err, nil
+}
+
+// Call events in order until the next
+// scheduled event is after t, including
+// any events scheduled by callbacks.
+// Return the number of events called,
+// and the number of events remaining
+// once the last one was called.
+// Skipped events (see ScheduleTTL)
+// are removed, but not counted.
+//
+// The internal clock is left at the
+// time of the last event reached; it
+// is not advanced to t.
+//
+// Returns ErrPast if t is before
+// s.Now(), in which case no events
+// are called. If an event before
+// s.Now() is reached, stop and
+// return ErrClockRegression.
+func (s *Scheduler) RunUntilN(t time.Time) (fired, remaining int, err error) {
+	if t.Before(s.now) {
+		return 0, s.Len(), ErrPast
+	}
+	for !s.Empty() && !s.heap.events[0].time.After(t) {
+		evt, err := s.next()
+		if err != nil {
+			return fired, s.Len(), err
+		}
+		if called, _, _ := s.fire(evt); called {
+			fired++
+		}
+	}
+	return fired, s.Len(), nil
+}
+
+// Call events in order until there
+// are no events scheduled, including
+// any events scheduled by callbacks.
+//
+// If the callback of an event scheduled
+// with ScheduleE or ScheduleRE returns a
+// non-nil error, stop and return an
+// *EventError holding that error and the
+// event's time. Events scheduled after
+// it remain scheduled. If an event
+// before s.Now() is reached, stop and
+// return ErrClockRegression.
+func (s *Scheduler) RunAllE() error {
+	for !s.Empty() {
+		evt, err := s.next()
+		if err != nil {
+			return err
+		}
+		if _, _, err := s.fire(evt); err != nil {
+			return &EventError{evt.time, err}
+		}
 	}
-	evt := heap.Pop(s.heap).(event)
-	s.now = evt.time
-	return evt.f(evt.time), nil
+	return nil
 }
 
 // Remove the next scheduled event
@@ -160,7 +1229,7 @@ func (s *Scheduler) CallNext() (interface{}, error) {
 // alter the internal clock.
 func (s *Scheduler) RemoveNext() {
 	if !s.Empty() {
-		heap.Pop(s.heap)
+		s.pop()
 	}
 }
 
@@ -169,35 +1238,132 @@ func (s *Scheduler) RemoveNext() {
 // and remove the event from the
 // Scheduler. If there are no events
 // scheduled, do not alter the clock.
+// If the next event is before
+// s.Now(), it is still removed, but
+// the clock is not moved back to it.
 func (s *Scheduler) RemoveNextUpdate() {
-	if !s.Empty() {
-		evt := heap.Pop(s.heap).(event)
-		s.now = evt.time
+	s.RemoveNextUpdateOK()
+}
+
+// Like RemoveNextUpdate, but return
+// whether an event was removed. This
+// is false only if there are no
+// events scheduled; an event before
+// s.Now() is still removed.
+func (s *Scheduler) RemoveNextUpdateOK() bool {
+	if s.Empty() {
+		return false
 	}
+	evt := s.pop()
+	// evt is removed even if it is
+	// before s.now; the clock is then
+	// left unchanged.
+	s.advance(evt.time)
+	return true
+}
+
+// Remove all scheduled events
+// before t without calling them,
+// and fast-forward the internal
+// clock to t. Return the number
+// of events removed.
+//
+// Returns ErrPast if t is before
+// s.Now(), in which case s is
+// not modified.
+func (s *Scheduler) SkipUntil(t time.Time) (int, error) {
+	if t.Before(s.now) {
+		return 0, ErrPast
+	}
+	n := 0
+	for !s.Empty() && s.heap.events[0].time.Before(t) {
+		s.pop()
+		n++
+	}
+	// t is not before s.now, so this
+	// cannot fail.
+	s.advance(t)
+	return n, nil
+}
+
+// Remove all scheduled events
+// before s.Now() without calling
+// them, and return the number of
+// events removed. Since events can
+// never be scheduled in the past,
+// this should always return 0; it
+// is provided as a consistency check.
+func (s *Scheduler) DiscardPast() int {
+	return s.heap.filter(func(evt event) bool {
+		return !evt.time.Before(s.now)
+	})
 }
 
 // Remove all scheduled events from
 // the Scheduler, but do not alter
 // the internal clock.
 func (s *Scheduler) RemoveAll() {
-	*s.heap = make([]event, 0)
+	s.heap.events = make([]event, 0)
 }
 
 // Remove all scheduled events from
 // the Scheduler, fast-forwarding
 // the internal clock to match the
 // latest scheduled event. If there
-// are no events scheduled, do not
+// are no events scheduled, or the
+// latest is before s.Now(), do not
 // alter the clock.
 func (s *Scheduler) RemoveAllUpdate() {
-	if !s.Empty() {
-		latest := (*s.heap)[0].time
-		for _, evt := range (*s.heap)[1:] {
-			if evt.time.After(latest) {
-				latest = evt.time
-			}
+	s.RemoveAllUpdateOK()
+}
+
+// Like RemoveAllUpdate, but return
+// whether any events were removed.
+func (s *Scheduler) RemoveAllUpdateOK() bool {
+	if s.Empty() {
+		return false
+	}
+	latest := s.heap.events[0].time
+	for _, evt := range s.heap.events[1:] {
+		if evt.time.After(latest) {
+			latest = evt.time
 		}
-		s.now = latest
 	}
-	*s.heap = make([]event, 0)
+	// Events are removed even if they
+	// are all before s.now; the clock
+	// is then left unchanged.
+	s.advance(latest)
+	s.heap.events = make([]event, 0)
+	return true
+}
+
+// Close s so that all subsequent
+// attempts to schedule events return
+// ErrClosed. Events which are already
+// scheduled are unaffected, and may
+// still be called or removed.
+//
+// Calling Close on a closed Scheduler
+// has no effect. Close always returns
+// nil.
+func (s *Scheduler) Close() error {
+	s.closed = true
+	return nil
+}
+
+// Close s, and then call all events
+// which are already scheduled, in
+// order. Since s is closed, callbacks
+// cannot schedule further events, so
+// CloseDrain always terminates. If
+// an event before s.Now() is reached,
+// stop and return ErrClockRegression.
+func (s *Scheduler) CloseDrain() error {
+	s.Close()
+	for !s.Empty() {
+		if _, err := s.CallNext(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -5,7 +5,11 @@
 package fsched
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"math/rand"
+	"sort"
 	"testing"
 	"time"
 )
@@ -264,3 +268,1405 @@ func TestRemoveAllUpdate(t *testing.T) {
 		t.Errorf("Expected time %v; got %v", tm, tmprime)
 	}
 }
+
+func TestScheduleValue(t *testing.T) {
+	s := NewScheduler()
+	f := func(tm time.Time, val interface{}) interface{} {
+		return val
+	}
+	for i := 0; i < 10; i++ {
+		s.ScheduleValue(f, Zero.Add(time.Duration(i)), i)
+	}
+	for i := 0; i < 10; i++ {
+		v, _ := s.CallNext()
+		if v != i {
+			t.Errorf("Expected value %v; got %v", i, v)
+		}
+	}
+
+	s = NewSchedulerTime(NanoAfterZero)
+	err := s.ScheduleValue(f, Zero, nil)
+	if err != ErrPast {
+		t.Errorf("Expected error %v; got %v", ErrPast, err)
+	}
+}
+
+func TestScheduleCtxFunc(t *testing.T) {
+	s := NewScheduler()
+	ctx := context.Background()
+	cctx, cancel := context.WithCancel(ctx)
+	called := false
+	f := func(c context.Context, tm time.Time) interface{} {
+		if c != ctx {
+			t.Errorf("Expected context %v; got %v", ctx, c)
+		}
+		return tm
+	}
+	g := func(c context.Context, tm time.Time) interface{} {
+		called = true
+		return tm
+	}
+	s.ScheduleCtxFunc(ctx, f, NanoAfterZero)
+	s.ScheduleCtxFunc(cctx, g, NanoAfterZero.Add(time.Nanosecond))
+	cancel()
+
+	v, _ := s.CallNext()
+	if v != NanoAfterZero {
+		t.Errorf("Expected value %v; got %v", NanoAfterZero, v)
+	}
+	v, _ = s.CallNext()
+	if v != nil {
+		t.Errorf("Expected nil value; got %v", v)
+	}
+	if called {
+		t.Error("Callback with canceled context was called")
+	}
+	if tm := NanoAfterZero.Add(time.Nanosecond); s.Now() != tm {
+		t.Errorf("Expected time %v; got %v", tm, s.Now())
+	}
+}
+
+func TestScheduleE(t *testing.T) {
+	errTest := errors.New("test")
+	i := 0
+	f := func(tm time.Time) error {
+		i++
+		return nil
+	}
+	s := NewScheduler()
+	for j := 0; j < 10; j++ {
+		s.ScheduleE(f, Zero.Add(time.Duration(j)))
+	}
+	if err := s.RunAllE(); err != nil {
+		t.Errorf("Expected nil error; got %v", err)
+	}
+	if i != 10 {
+		t.Errorf("Expected %v calls; got %v", 10, i)
+	}
+
+	s = NewScheduler()
+	s.ScheduleE(func(tm time.Time) error { return errTest }, NanoAfterZero)
+	s.ScheduleE(f, NanoAfterZero.Add(time.Nanosecond))
+	err := s.RunAllE()
+	eerr, ok := err.(*EventError)
+	if !ok {
+		t.Fatalf("Expected *EventError; got %v", err)
+	}
+	if eerr.Err != errTest || eerr.Time != NanoAfterZero {
+		t.Errorf("Expected error %v at %v; got %v at %v", errTest, NanoAfterZero, eerr.Err, eerr.Time)
+	}
+	if s.Empty() {
+		t.Error("Scheduler should not be empty")
+	}
+
+	s = NewSchedulerTime(NanoAfterZero)
+	if err := s.ScheduleE(f, Zero); err != ErrPast {
+		t.Errorf("Expected error %v; got %v", ErrPast, err)
+	}
+}
+
+func TestScheduleRE(t *testing.T) {
+	errTest := errors.New("test")
+	s := NewScheduler()
+	s.ScheduleRE(func(tm time.Time) (interface{}, error) { return 1, nil }, Zero)
+	s.ScheduleRE(func(tm time.Time) (interface{}, error) { return 2, errTest }, NanoAfterZero)
+	s.Schedule(func(tm time.Time) interface{} { return 3 }, NanoAfterZero.Add(time.Nanosecond))
+
+	tm, v, cerr, err := s.StepRE()
+	if tm != Zero || v != 1 || cerr != nil || err != nil {
+		t.Errorf("Expected (%v, 1, <nil>, <nil>); got (%v, %v, %v, %v)", Zero, tm, v, cerr, err)
+	}
+	tm, v, cerr, err = s.StepRE()
+	if tm != NanoAfterZero || v != 2 || cerr != errTest || err != nil {
+		t.Errorf("Expected (%v, 2, %v, <nil>); got (%v, %v, %v, %v)", NanoAfterZero, errTest, tm, v, cerr, err)
+	}
+	tm, v, cerr, err = s.StepRE()
+	if v != 3 || cerr != nil || err != nil {
+		t.Errorf("Expected (3, <nil>, <nil>); got (%v, %v, %v)", v, cerr, err)
+	}
+	_, _, _, err = s.StepRE()
+	if err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+
+	s = NewScheduler()
+	s.ScheduleRE(func(tm time.Time) (interface{}, error) { return 2, errTest }, NanoAfterZero)
+	v, _ = s.CallNext()
+	if v != errTest {
+		t.Errorf("Expected value %v; got %v", errTest, v)
+	}
+}
+
+func TestScheduleCallNextAllocs(t *testing.T) {
+	// Scheduling and calling events must
+	// not allocate once the heap has grown.
+	f := func(tm time.Time) interface{} { return nil }
+	s := NewScheduler()
+	for i := 0; i < 1000; i++ {
+		s.ScheduleOffset(f, time.Duration(i))
+	}
+	allocs := testing.AllocsPerRun(1000, func() {
+		s.CallNext()
+		s.ScheduleOffset(f, 1000)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected 0 allocations per event; got %v", allocs)
+	}
+}
+
+func BenchmarkScheduleCallNext(b *testing.B) {
+	// Keep a steady population of events,
+	// scheduling a new event for each one
+	// that fires.
+	f := func(tm time.Time) interface{} { return nil }
+	s := NewScheduler()
+	for i := 0; i < 1000; i++ {
+		s.ScheduleOffset(f, time.Duration(rand.Intn(1000)))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.CallNext()
+		s.ScheduleOffset(f, time.Duration(rand.Intn(1000)))
+	}
+}
+
+func TestSetGrowthHint(t *testing.T) {
+	s := NewScheduler()
+	s.SetGrowthHint(10)
+	for i := 0; i < 25; i++ {
+		s.Schedule(nil, Zero.Add(time.Duration(i)))
+	}
+	if c := cap(s.heap.events); c != 30 {
+		t.Errorf("Expected capacity %v; got %v", 30, c)
+	}
+	for i := 0; i < 25; i++ {
+		p, _ := s.PeekNext()
+		if tm := Zero.Add(time.Duration(i)); p != tm {
+			t.Errorf("Expected PeekNext() to return %v; returned %v", tm, p)
+		}
+		s.RemoveNext()
+	}
+}
+
+func benchmarkBulkLoad(b *testing.B, hint int) {
+	// Load events in stages, as happens
+	// when a simulation is set up piecemeal.
+	const stages, perStage = 10, 10000
+	var slots int
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := NewScheduler()
+		s.SetGrowthHint(hint)
+		for j := 0; j < stages; j++ {
+			for k := 0; k < perStage; k++ {
+				s.ScheduleOffset(nil, time.Duration(k))
+			}
+		}
+		slots = cap(s.heap.events)
+	}
+	b.Logf("%d slots held after loading", slots)
+}
+
+func BenchmarkBulkLoad(b *testing.B)           { benchmarkBulkLoad(b, 0) }
+func BenchmarkBulkLoadGrowthHint(b *testing.B) { benchmarkBulkLoad(b, 10000) }
+
+func TestLen(t *testing.T) {
+	s := NewScheduler()
+	for i := 0; i < 10; i++ {
+		if l := s.Len(); l != i {
+			t.Errorf("Expected length %v; got %v", i, l)
+		}
+		s.Schedule(nil, Zero)
+	}
+}
+
+func TestNewSchedulerBounded(t *testing.T) {
+	f := func(tm time.Time) interface{} { return nil }
+	s := NewSchedulerBounded(3)
+	for i := 0; i < 3; i++ {
+		if err := s.Schedule(f, Zero); err != nil {
+			t.Errorf("Expected nil error; got %v", err)
+		}
+	}
+	if err := s.Schedule(f, Zero); err != ErrFull {
+		t.Errorf("Expected error %v; got %v", ErrFull, err)
+	}
+	if err := s.ScheduleOffset(f, 0); err != ErrFull {
+		t.Errorf("Expected error %v; got %v", ErrFull, err)
+	}
+	s.CallNext()
+	if err := s.Schedule(f, Zero); err != nil {
+		t.Errorf("Expected nil error; got %v", err)
+	}
+
+	s = NewSchedulerBounded(0)
+	for i := 0; i < 100; i++ {
+		if err := s.Schedule(f, Zero); err != nil {
+			t.Errorf("Expected nil error; got %v", err)
+		}
+	}
+}
+
+func TestNewSchedulerBoundedEvict(t *testing.T) {
+	f := func(tm time.Time) interface{} { return nil }
+	t1, t2, t3 := Zero.Add(1), Zero.Add(2), Zero.Add(3)
+
+	s := NewSchedulerBoundedEvict(2, RejectNew)
+	s.Schedule(f, t1)
+	s.Schedule(f, t2)
+	if _, ok, err := s.ScheduleEvict(f, Zero); ok || err != ErrFull {
+		t.Errorf("Expected (false, %v); got (%v, %v)", ErrFull, ok, err)
+	}
+
+	s = NewSchedulerBoundedEvict(2, EvictNewest)
+	s.Schedule(f, t1)
+	s.Schedule(f, t3)
+	s.Schedule(f, t2)
+	evicted, ok, err := s.ScheduleEvict(f, t1)
+	if evicted != t2 || !ok || err != nil {
+		t.Errorf("Expected (%v, true, <nil>); got (%v, %v, %v)", t2, evicted, ok, err)
+	}
+	if l := s.Len(); l != 2 {
+		t.Errorf("Expected length %v; got %v", 2, l)
+	}
+
+	s = NewSchedulerBoundedEvict(2, EvictLatest)
+	s.Schedule(f, t3)
+	s.Schedule(f, t1)
+	evicted, ok, err = s.ScheduleEvict(f, t2)
+	if evicted != t3 || !ok || err != nil {
+		t.Errorf("Expected (%v, true, <nil>); got (%v, %v, %v)", t3, evicted, ok, err)
+	}
+	if _, ok, err = s.ScheduleEvict(f, t3); ok || err != ErrFull {
+		t.Errorf("Expected (false, %v); got (%v, %v)", ErrFull, ok, err)
+	}
+	for _, tm := range []time.Time{t1, t2} {
+		if p, _ := s.PeekNext(); p != tm {
+			t.Errorf("Expected PeekNext() to return %v; returned %v", tm, p)
+		}
+		s.RemoveNext()
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestScheduleTTL(t *testing.T) {
+	c := &fakeClock{}
+	s := NewScheduler()
+	s.SetClock(c)
+	f := func(tm time.Time) interface{} { return tm }
+	t1, t2 := Zero.Add(1), Zero.Add(2)
+	s.ScheduleTTL(f, t1, time.Second)
+	s.ScheduleTTL(f, t2, time.Minute)
+	c.now = c.now.Add(time.Second)
+
+	v, _ := s.CallNext()
+	if v != t1 {
+		t.Errorf("Expected value %v; got %v", t1, v)
+	}
+	c.now = c.now.Add(time.Minute)
+	v, _ = s.CallNext()
+	if v != nil {
+		t.Errorf("Expected nil value; got %v", v)
+	}
+	if tm := s.Now(); tm != t2 {
+		t.Errorf("Expected time %v; got %v", t2, tm)
+	}
+}
+
+func TestSkippedNotFired(t *testing.T) {
+	c := &fakeClock{}
+	s := NewScheduler()
+	s.SetClock(c)
+	ctx, cancel := context.WithCancel(context.Background())
+	f := func(tm time.Time) interface{} { return tm }
+	g := func(c context.Context, tm time.Time) interface{} { return tm }
+	s.Schedule(f, NanoAfterZero)
+	s.ScheduleTTL(f, NanoAfterZero, time.Second)
+	s.ScheduleCtxFunc(ctx, g, NanoAfterZero)
+	s.ScheduleCtxFunc(ctx, g, NanoAfterZero)
+	c.now = c.now.Add(time.Minute)
+	cancel()
+
+	fired, remaining, err := s.RunUntilN(NanoAfterZero)
+	if err != nil {
+		t.Errorf("Expected nil error; got %v", err)
+	}
+	if fired != 1 || remaining != 0 {
+		t.Errorf("Expected 1 fired and 0 remaining; got %v and %v", fired, remaining)
+	}
+	if n := s.FiredAtNow(); n != 1 {
+		t.Errorf("Expected %v events fired; got %v", 1, n)
+	}
+
+	s.ScheduleTTL(f, NanoAfterZero, time.Second)
+	c.now = c.now.Add(time.Minute)
+	if v, ok, _ := s.CallOrDrop(func(time.Time) bool { return true }); v != nil || ok {
+		t.Errorf("Expected nil and false; got %v and %v", v, ok)
+	}
+	if n := s.FiredAtNow(); n != 1 {
+		t.Errorf("Expected %v events fired; got %v", 1, n)
+	}
+}
+
+func TestClose(t *testing.T) {
+	i := 0
+	f := func(tm time.Time) interface{} {
+		i++
+		return nil
+	}
+	s := NewScheduler()
+	s.Schedule(f, Zero)
+	if err := s.Close(); err != nil {
+		t.Errorf("Expected nil error; got %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Expected nil error; got %v", err)
+	}
+	if err := s.Schedule(f, Zero); err != ErrClosed {
+		t.Errorf("Expected error %v; got %v", ErrClosed, err)
+	}
+	if err := s.ScheduleE(nil, Zero); err != ErrClosed {
+		t.Errorf("Expected error %v; got %v", ErrClosed, err)
+	}
+	if _, err := s.CallNext(); err != nil || i != 1 {
+		t.Errorf("Expected to drain 1 event; drained %v (error %v)", i, err)
+	}
+}
+
+func TestCloseDrain(t *testing.T) {
+	i := 0
+	s := NewScheduler()
+	var f func(tm time.Time) interface{}
+	f = func(tm time.Time) interface{} {
+		i++
+		// Re-arming forever must not prevent
+		// CloseDrain from terminating.
+		if err := s.ScheduleOffset(f, 1); err != ErrClosed {
+			t.Errorf("Expected error %v; got %v", ErrClosed, err)
+		}
+		return nil
+	}
+	s.Schedule(f, Zero)
+	s.Schedule(f, NanoAfterZero)
+	if err := s.CloseDrain(); err != nil {
+		t.Errorf("Expected nil error; got %v", err)
+	}
+	if i != 2 {
+		t.Errorf("Expected %v calls; got %v", 2, i)
+	}
+	if !s.Empty() {
+		t.Error("Scheduler should be empty")
+	}
+	if err := s.Schedule(f, s.Now()); err != ErrClosed {
+		t.Errorf("Expected error %v; got %v", ErrClosed, err)
+	}
+}
+
+func TestPeekNextOffset(t *testing.T) {
+	s := NewSchedulerTime(NanoAfterZero)
+	if _, err := s.PeekNextOffset(); err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+	s.Schedule(nil, NanoAfterZero)
+	if d, _ := s.PeekNextOffset(); d != 0 {
+		t.Errorf("Expected offset %v; got %v", time.Duration(0), d)
+	}
+	s.RemoveNext()
+	s.ScheduleOffset(nil, time.Second)
+	if d, _ := s.PeekNextOffset(); d != time.Second {
+		t.Errorf("Expected offset %v; got %v", time.Second, d)
+	}
+}
+
+func TestNextTimeAfter(t *testing.T) {
+	s := NewScheduler()
+	if _, err := s.NextTimeAfter(Zero); err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+	for _, v := range rand.Perm(10) {
+		s.Schedule(nil, Zero.Add(time.Duration(2*v)))
+	}
+	for i := 0; i < 18; i++ {
+		tm := Zero.Add(time.Duration(i))
+		expect := Zero.Add(time.Duration(i + 2 - i%2))
+		if next, _ := s.NextTimeAfter(tm); next != expect {
+			t.Errorf("Expected NextTimeAfter(%v) to return %v; returned %v", tm, expect, next)
+		}
+	}
+	if _, err := s.NextTimeAfter(Zero.Add(18)); err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+	if l := s.Len(); l != 10 {
+		t.Errorf("Expected length %v; got %v", 10, l)
+	}
+}
+
+func TestSkipUntil(t *testing.T) {
+	called := false
+	f := func(tm time.Time) interface{} {
+		called = true
+		return nil
+	}
+	s := NewScheduler()
+	for _, v := range rand.Perm(10) {
+		s.Schedule(f, Zero.Add(time.Duration(v)))
+	}
+	tm := Zero.Add(4)
+	if n, err := s.SkipUntil(tm); n != 4 || err != nil {
+		t.Errorf("Expected (4, <nil>); got (%v, %v)", n, err)
+	}
+	if called {
+		t.Error("Skipped callback was called")
+	}
+	if tmprime := s.Now(); tmprime != tm {
+		t.Errorf("Expected time %v; got %v", tm, tmprime)
+	}
+	if p, _ := s.PeekNext(); p != tm {
+		t.Errorf("Expected PeekNext() to return %v; returned %v", tm, p)
+	}
+	if _, err := s.SkipUntil(Zero); err != ErrPast {
+		t.Errorf("Expected error %v; got %v", ErrPast, err)
+	}
+}
+
+func TestDiscardPast(t *testing.T) {
+	s := NewScheduler()
+	for _, v := range rand.Perm(10) {
+		s.Schedule(nil, Zero.Add(time.Duration(v)))
+	}
+	for !s.Empty() {
+		if n := s.DiscardPast(); n != 0 {
+			t.Errorf("Expected 0 events discarded; got %v", n)
+		}
+		s.RemoveNextUpdate()
+	}
+
+	// Corrupt the heap with events
+	// in the past, which cannot be
+	// done through the public API.
+	s = NewSchedulerTime(Zero.Add(5))
+	for _, v := range rand.Perm(10) {
+		s.heap.push(event{time: Zero.Add(time.Duration(v))})
+	}
+	if n := s.DiscardPast(); n != 5 {
+		t.Errorf("Expected %v events discarded; got %v", 5, n)
+	}
+	for i := 5; i < 10; i++ {
+		tm := Zero.Add(time.Duration(i))
+		if p, _ := s.PeekNext(); p != tm {
+			t.Errorf("Expected PeekNext() to return %v; returned %v", tm, p)
+		}
+		s.RemoveNext()
+	}
+}
+
+func TestTimes(t *testing.T) {
+	s := NewScheduler()
+	if times := s.Times(); len(times) != 0 {
+		t.Errorf("Expected no times; got %v", times)
+	}
+	for _, v := range rand.Perm(10) {
+		s.Schedule(nil, Zero.Add(time.Duration(v)))
+	}
+	times := s.Times()
+	if len(times) != 10 {
+		t.Fatalf("Expected %v times; got %v", 10, len(times))
+	}
+	if times[0] != Zero {
+		t.Errorf("Expected first time %v; got %v", Zero, times[0])
+	}
+	seen := make(map[time.Time]bool)
+	for _, tm := range times {
+		seen[tm] = true
+	}
+	for i := 0; i < 10; i++ {
+		if tm := Zero.Add(time.Duration(i)); !seen[tm] {
+			t.Errorf("Expected time %v in %v", tm, times)
+		}
+	}
+}
+
+func TestSchedulePriority(t *testing.T) {
+	f := func(j int) func(tm time.Time) interface{} {
+		return func(tm time.Time) interface{} { return j }
+	}
+	s := NewScheduler()
+	s.SchedulePriority(f(4), Zero, -1)
+	s.Schedule(f(2), Zero)
+	s.SchedulePriority(f(0), Zero, 1)
+	s.Schedule(f(3), Zero)
+	s.SchedulePriority(f(1), Zero, 1)
+	s.SchedulePriority(f(5), NanoAfterZero, 2)
+	for i := 0; i < 6; i++ {
+		if v, _ := s.CallNext(); v != i {
+			t.Errorf("Expected value %v; got %v", i, v)
+		}
+	}
+}
+
+func TestNewSchedulerPolicy(t *testing.T) {
+	f := func(j int) func(tm time.Time) interface{} {
+		return func(tm time.Time) interface{} { return j }
+	}
+	for _, c := range []struct {
+		policy EqualTimePolicy
+		expect []int
+	}{
+		{Priority, []int{2, 0, 1, 3}},
+		{FIFO, []int{0, 1, 2, 3}},
+		{LIFO, []int{3, 2, 1, 0}},
+	} {
+		s := NewSchedulerPolicy(c.policy)
+		s.Schedule(f(0), Zero)
+		s.Schedule(f(1), Zero)
+		s.SchedulePriority(f(2), Zero, 1)
+		s.SchedulePriority(f(3), Zero, -1)
+		for _, j := range c.expect {
+			if v, _ := s.CallNext(); v != j {
+				t.Errorf("Policy %v: expected value %v; got %v", c.policy, j, v)
+			}
+		}
+	}
+}
+
+func TestOffsetIterator(t *testing.T) {
+	s := NewSchedulerTime(NanoAfterZero)
+	for _, v := range rand.Perm(10) {
+		s.ScheduleOffset(nil, time.Duration(v))
+	}
+	it := s.OffsetIterator()
+	s.RemoveAllUpdate()
+	for i := 0; i < 10; i++ {
+		d, ok := it.Next()
+		if !ok || d != time.Duration(i) {
+			t.Errorf("Expected (%v, true); got (%v, %v)", time.Duration(i), d, ok)
+		}
+	}
+	if d, ok := it.Next(); ok {
+		t.Errorf("Expected exhausted iterator; got %v", d)
+	}
+}
+
+func TestScheduleEarliest(t *testing.T) {
+	s := NewScheduler()
+	t1, t2 := Zero.Add(1), Zero.Add(2)
+	for _, c := range []struct {
+		tm     time.Time
+		expect bool
+	}{
+		{t2, true},
+		{t2, false},
+		{t1, true},
+		{t2, false},
+		{t1, false},
+	} {
+		if ok, err := s.ScheduleEarliest(nil, c.tm); ok != c.expect || err != nil {
+			t.Errorf("Expected (%v, <nil>); got (%v, %v)", c.expect, ok, err)
+		}
+	}
+
+	s = NewSchedulerPolicy(LIFO)
+	s.Schedule(nil, t1)
+	if ok, _ := s.ScheduleEarliest(nil, t1); !ok {
+		t.Error("Expected event to become earliest")
+	}
+
+	s = NewSchedulerTime(t1)
+	if ok, err := s.ScheduleEarliest(nil, Zero); ok || err != ErrPast {
+		t.Errorf("Expected (false, %v); got (%v, %v)", ErrPast, ok, err)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewScheduler()
+	s.Schedule(nil, NanoAfterZero)
+	s.Schedule(nil, Zero)
+	if err := s.WriteDOT(&buf); err != nil {
+		t.Fatalf("Expected nil error; got %v", err)
+	}
+	expect := "digraph fsched {\n" +
+		"\te0 [label=\"" + Zero.String() + "\"];\n" +
+		"\te1 [label=\"" + NanoAfterZero.String() + "\"];\n" +
+		"\te0 -> e1;\n" +
+		"}\n"
+	if got := buf.String(); got != expect {
+		t.Errorf("Expected:\n%v\ngot:\n%v", expect, got)
+	}
+	if l := s.Len(); l != 2 {
+		t.Errorf("Expected length %v; got %v", 2, l)
+	}
+}
+
+func TestRateInWindow(t *testing.T) {
+	s := NewSchedulerTime(NanoAfterZero)
+	for i := 0; i < 10; i++ {
+		s.ScheduleOffset(nil, time.Duration(i)*100*time.Millisecond)
+	}
+	for _, c := range []struct {
+		window time.Duration
+		expect float64
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{500 * time.Millisecond, 10},
+		{time.Second, 10},
+		{2 * time.Second, 5},
+	} {
+		if r := s.RateInWindow(c.window); r != c.expect {
+			t.Errorf("Expected RateInWindow(%v) to return %v; returned %v", c.window, c.expect, r)
+		}
+	}
+}
+
+func TestBin(t *testing.T) {
+	s := NewScheduler()
+	if counts, bounds := s.Bin(3); counts != nil || bounds != nil {
+		t.Errorf("Expected nil slices; got %v, %v", counts, bounds)
+	}
+	for _, v := range []int{0, 1, 2, 3, 4, 5, 6, 9, 9, 9} {
+		s.Schedule(nil, Zero.Add(time.Duration(v)))
+	}
+	if counts, bounds := s.Bin(0); counts != nil || bounds != nil {
+		t.Errorf("Expected nil slices; got %v, %v", counts, bounds)
+	}
+	counts, bounds := s.Bin(3)
+	expectCounts := []int{3, 3, 4}
+	expectBounds := []time.Time{Zero, Zero.Add(3), Zero.Add(6), Zero.Add(9)}
+	for i := range expectCounts {
+		if counts[i] != expectCounts[i] {
+			t.Errorf("Expected counts %v; got %v", expectCounts, counts)
+			break
+		}
+	}
+	for i := range expectBounds {
+		if bounds[i] != expectBounds[i] {
+			t.Errorf("Expected bounds %v; got %v", expectBounds, bounds)
+			break
+		}
+	}
+
+	s = NewScheduler()
+	for i := 0; i < 3; i++ {
+		s.Schedule(nil, NanoAfterZero)
+	}
+	counts, bounds = s.Bin(4)
+	if len(counts) != 4 || counts[0] != 3 || len(bounds) != 5 {
+		t.Errorf("Expected all events in first bucket; got %v, %v", counts, bounds)
+	}
+}
+
+func TestCallAllAt(t *testing.T) {
+	s := NewScheduler()
+	if _, _, err := s.CallAllAt(); err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+	f := func(j int) func(tm time.Time) interface{} {
+		return func(tm time.Time) interface{} {
+			// Should not be called in the same batch
+			s.Schedule(func(tm time.Time) interface{} { return -1 }, tm)
+			return j
+		}
+	}
+	for i := 0; i < 5; i++ {
+		s.Schedule(f(i), NanoAfterZero)
+	}
+	s.Schedule(f(5), NanoAfterZero.Add(time.Nanosecond))
+
+	tm, vals, err := s.CallAllAt()
+	if tm != NanoAfterZero || err != nil {
+		t.Errorf("Expected (%v, <nil>); got (%v, %v)", NanoAfterZero, tm, err)
+	}
+	if len(vals) != 5 {
+		t.Fatalf("Expected %v values; got %v", 5, vals)
+	}
+	for i, v := range vals {
+		if v != i {
+			t.Errorf("Expected value %v; got %v", i, v)
+		}
+	}
+	if tmprime := s.Now(); tmprime != NanoAfterZero {
+		t.Errorf("Expected time %v; got %v", NanoAfterZero, tmprime)
+	}
+	if l := s.Len(); l != 6 {
+		t.Errorf("Expected length %v; got %v", 6, l)
+	}
+}
+
+func TestCallAllAtPriority(t *testing.T) {
+	f := func(j int) func(tm time.Time) interface{} {
+		return func(tm time.Time) interface{} { return j }
+	}
+	s := NewScheduler()
+	s.SchedulePriority(f(5), Zero, -2)
+	s.Schedule(f(3), Zero)
+	s.SchedulePriority(f(1), Zero, 2)
+	s.SchedulePriority(f(5), NanoAfterZero, 5)
+	s.SchedulePriority(f(4), Zero, -1)
+	s.SchedulePriority(f(2), Zero, 2)
+	s.SchedulePriority(f(0), Zero, 3)
+	_, vals, _ := s.CallAllAt()
+	if len(vals) != 6 {
+		t.Fatalf("Expected %v values; got %v", 6, vals)
+	}
+	for i, v := range []int{0, 1, 2, 3, 4, 5} {
+		if vals[i] != v {
+			t.Errorf("Expected values [0 1 2 3 4 5]; got %v", vals)
+			break
+		}
+	}
+}
+
+func TestFIFOProperty(t *testing.T) {
+	// This test schedules thousands of events,
+	// many sharing timestamps, interleaved
+	// with calls to CallNext, and verifies
+	// that events are called in order of time,
+	// and events with equal times in the order
+	// in which they were scheduled.
+	type fired struct {
+		tm  time.Time
+		seq int
+	}
+	r := rand.New(rand.NewSource(1))
+	var order []fired
+	f := func(seq int) func(tm time.Time) interface{} {
+		return func(tm time.Time) interface{} {
+			order = append(order, fired{tm, seq})
+			return nil
+		}
+	}
+	s := NewScheduler()
+	for i := 0; i < 5000; i++ {
+		s.ScheduleOffset(f(i), time.Duration(r.Intn(50)))
+		if r.Intn(3) == 0 {
+			s.CallNext()
+		}
+	}
+	for !s.Empty() {
+		s.CallNext()
+	}
+	if len(order) != 5000 {
+		t.Fatalf("Expected %v events called; got %v", 5000, len(order))
+	}
+	for i := 1; i < len(order); i++ {
+		a, b := order[i-1], order[i]
+		if b.tm.Before(a.tm) || (b.tm.Equal(a.tm) && b.seq < a.seq) {
+			t.Fatalf("Event %v called after event %v", b, a)
+		}
+	}
+}
+
+func TestCountDueBy(t *testing.T) {
+	s := NewScheduler()
+	if n := s.CountDueBy(Zero); n != 0 {
+		t.Errorf("Expected count %v; got %v", 0, n)
+	}
+	for _, v := range rand.Perm(10) {
+		s.Schedule(nil, Zero.Add(time.Duration(v)))
+	}
+	for i := 0; i < 12; i++ {
+		expect := i + 1
+		if expect > 10 {
+			expect = 10
+		}
+		if n := s.CountDueBy(Zero.Add(time.Duration(i))); n != expect {
+			t.Errorf("Expected count %v; got %v", expect, n)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(2)
+	s := NewScheduler()
+	if err := h.Undo(s); err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+	for i := 0; i < 5; i++ {
+		s.Schedule(nil, Zero.Add(time.Duration(i)))
+	}
+	for i := 0; i < 3; i++ {
+		h.Push(s)
+		s.RemoveNextUpdate()
+	}
+	if l := h.Len(); l != 2 {
+		t.Errorf("Expected length %v; got %v", 2, l)
+	}
+	for _, i := range []int{2, 1} {
+		if err := h.Undo(s); err != nil {
+			t.Errorf("Expected nil error; got %v", err)
+		}
+		tm := Zero.Add(time.Duration(i - 1))
+		if tmprime := s.Now(); tmprime != tm {
+			t.Errorf("Expected time %v; got %v", tm, tmprime)
+		}
+		if l := s.Len(); l != 5-i {
+			t.Errorf("Expected length %v; got %v", 5-i, l)
+		}
+	}
+	if err := h.Undo(s); err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+}
+
+func TestSetAutoCompact(t *testing.T) {
+	s := NewScheduler()
+	s.SetAutoCompact(0.25)
+	for i := 0; i < 100; i++ {
+		s.Schedule(nil, Zero.Add(time.Duration(i)))
+	}
+	c := cap(s.heap.events)
+	for i := 0; i < 90; i++ {
+		s.RemoveNext()
+		if l, c := s.Len(), cap(s.heap.events); float64(l) < float64(c)*0.25 {
+			t.Fatalf("Expected capacity below %v; got %v", 4*l, c)
+		}
+	}
+	if cprime := cap(s.heap.events); cprime >= c {
+		t.Errorf("Expected capacity below %v; got %v", c, cprime)
+	}
+	for i := 90; i < 100; i++ {
+		tm := Zero.Add(time.Duration(i))
+		if p, _ := s.PeekNext(); p != tm {
+			t.Errorf("Expected PeekNext() to return %v; returned %v", tm, p)
+		}
+		s.RemoveNext()
+	}
+}
+
+func benchmarkRampDrain(b *testing.B, threshold float64) {
+	// Ramp up to a large schedule, and then
+	// drain most of it, reporting how much
+	// storage is still held afterwards.
+	f := func(tm time.Time) interface{} { return nil }
+	var slots int
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := NewScheduler()
+		s.SetAutoCompact(threshold)
+		for j := 0; j < 100000; j++ {
+			s.ScheduleOffset(f, time.Duration(j))
+		}
+		for j := 0; j < 99000; j++ {
+			s.CallNext()
+		}
+		slots = cap(s.heap.events)
+	}
+	b.Logf("%d slots held after draining", slots)
+}
+
+func BenchmarkRampDrain(b *testing.B)            { benchmarkRampDrain(b, 0) }
+func BenchmarkRampDrainAutoCompact(b *testing.B) { benchmarkRampDrain(b, 0.25) }
+
+func TestCallOrDrop(t *testing.T) {
+	s := NewScheduler()
+	if _, _, err := s.CallOrDrop(nil); err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+	f := func(tm time.Time) interface{} { return tm }
+	t1, t2 := Zero.Add(1), Zero.Add(2)
+	s.Schedule(f, t1)
+	s.Schedule(f, t2)
+	pred := func(tm time.Time) bool { return tm == t2 }
+
+	v, ok, err := s.CallOrDrop(pred)
+	if v != nil || ok || err != nil {
+		t.Errorf("Expected (<nil>, false, <nil>); got (%v, %v, %v)", v, ok, err)
+	}
+	if tm := s.Now(); tm != t1 {
+		t.Errorf("Expected time %v; got %v", t1, tm)
+	}
+	v, ok, err = s.CallOrDrop(pred)
+	if v != t2 || !ok || err != nil {
+		t.Errorf("Expected (%v, true, <nil>); got (%v, %v, %v)", t2, v, ok, err)
+	}
+	if !s.Empty() {
+		t.Error("Scheduler should be empty")
+	}
+}
+
+func TestScheduleOffsetClamp(t *testing.T) {
+	s := NewSchedulerTime(NanoAfterZero)
+	if err := s.ScheduleOffsetClamp(nil, -time.Second); err != nil {
+		t.Errorf("Expected nil error; got %v", err)
+	}
+	if p, _ := s.PeekNext(); p != NanoAfterZero {
+		t.Errorf("Expected PeekNext() to return %v; returned %v", NanoAfterZero, p)
+	}
+	s.RemoveNext()
+	s.ScheduleOffsetClamp(nil, time.Nanosecond)
+	if tm := NanoAfterZero.Add(time.Nanosecond); s.Times()[0] != tm {
+		t.Errorf("Expected time %v; got %v", tm, s.Times()[0])
+	}
+}
+
+func TestScheduleTimes(t *testing.T) {
+	i := 0
+	f := func(tm time.Time) interface{} {
+		i++
+		return tm
+	}
+	s := NewScheduler()
+	s.Schedule(f, Zero.Add(5))
+	var times []time.Time
+	for _, v := range rand.Perm(5) {
+		times = append(times, Zero.Add(time.Duration(2*v)))
+	}
+	if err := s.ScheduleTimes(f, times); err != nil {
+		t.Errorf("Expected nil error; got %v", err)
+	}
+	for _, v := range []int{0, 2, 4, 5, 6, 8} {
+		tm := Zero.Add(time.Duration(v))
+		if got, _ := s.CallNext(); got != tm {
+			t.Errorf("Expected value %v; got %v", tm, got)
+		}
+	}
+	if i != 6 {
+		t.Errorf("Expected %v calls; got %v", 6, i)
+	}
+
+	s = NewSchedulerTime(NanoAfterZero)
+	err := s.ScheduleTimes(f, []time.Time{NanoAfterZero, Zero, Zero.Add(-1)})
+	if eerr, ok := err.(*EventError); !ok || eerr.Err != ErrPast || eerr.Time != Zero {
+		t.Errorf("Expected error %v at %v; got %v", ErrPast, Zero, err)
+	}
+	if !s.Empty() {
+		t.Error("Scheduler should be empty")
+	}
+
+	s = NewSchedulerBounded(2)
+	if err := s.ScheduleTimes(f, []time.Time{Zero, Zero, Zero}); err != ErrFull {
+		t.Errorf("Expected error %v; got %v", ErrFull, err)
+	}
+	if !s.Empty() {
+		t.Error("Scheduler should be empty")
+	}
+}
+
+func TestRunUntilN(t *testing.T) {
+	s := NewScheduler()
+	var f func(tm time.Time) interface{}
+	f = func(tm time.Time) interface{} {
+		// Schedule a follow-up which falls
+		// inside the run for early events.
+		if tm.Before(Zero.Add(2)) {
+			s.ScheduleOffset(func(tm time.Time) interface{} { return nil }, 1)
+		}
+		return nil
+	}
+	for _, v := range rand.Perm(6) {
+		s.Schedule(f, Zero.Add(time.Duration(v)))
+	}
+	fired, remaining, err := s.RunUntilN(Zero.Add(3))
+	if fired != 6 || remaining != 2 || err != nil {
+		t.Errorf("Expected (6, 2, <nil>); got (%v, %v, %v)", fired, remaining, err)
+	}
+	if tm := Zero.Add(3); s.Now() != tm {
+		t.Errorf("Expected time %v; got %v", tm, s.Now())
+	}
+	if _, _, err := s.RunUntilN(Zero); err != ErrPast {
+		t.Errorf("Expected error %v; got %v", ErrPast, err)
+	}
+}
+
+func TestClockRegression(t *testing.T) {
+	// Corrupt the heap with an event in
+	// the past, which cannot be done through
+	// the public API, and verify that no
+	// method moves the clock backward or
+	// calls the event.
+	called := 0
+	f := func(tm time.Time) interface{} { called++; return nil }
+	now := Zero.Add(10)
+	for _, c := range []struct {
+		name string
+		fn   func(s *Scheduler) error
+		err  error
+	}{
+		{"CallNext", func(s *Scheduler) error { _, err := s.CallNext(); return err }, ErrClockRegression},
+		{"CallNextIfDue", func(s *Scheduler) error { _, _, err := s.CallNextIfDue(&fakeClock{now}); return err }, ErrClockRegression},
+		{"StepRE", func(s *Scheduler) error { _, _, _, err := s.StepRE(); return err }, ErrClockRegression},
+		{"RunAllE", func(s *Scheduler) error { return s.RunAllE() }, ErrClockRegression},
+		{"CallAllAt", func(s *Scheduler) error { _, _, err := s.CallAllAt(); return err }, ErrClockRegression},
+		{"CallOrDrop", func(s *Scheduler) error {
+			_, _, err := s.CallOrDrop(func(time.Time) bool { return true })
+			return err
+		}, ErrClockRegression},
+		{"RunUntilN", func(s *Scheduler) error { _, _, err := s.RunUntilN(now); return err }, ErrClockRegression},
+		{"CloseDrain", func(s *Scheduler) error { return s.CloseDrain() }, ErrClockRegression},
+		{"SkipUntil", func(s *Scheduler) error { _, err := s.SkipUntil(now); return err }, nil},
+		{"RemoveNextUpdate", func(s *Scheduler) error { s.RemoveNextUpdate(); return nil }, nil},
+		{"RemoveAllUpdate", func(s *Scheduler) error { s.RemoveAllUpdate(); return nil }, nil},
+	} {
+		called = 0
+		s := NewSchedulerTime(now)
+		s.heap.push(event{fn: f, time: Zero.Add(5)})
+		if err := c.fn(s); err != c.err {
+			t.Errorf("%v: expected error %v; got %v", c.name, c.err, err)
+		}
+		if called != 0 {
+			t.Errorf("%v: expected 0 calls; got %v", c.name, called)
+		}
+		if tm := s.Now(); tm != now {
+			t.Errorf("%v: expected time %v; got %v", c.name, now, tm)
+		}
+	}
+}
+
+func TestRemoveUpdateOK(t *testing.T) {
+	s := NewSchedulerTime(NanoAfterZero)
+	if s.RemoveNextUpdateOK() {
+		t.Error("RemoveNextUpdateOK() returned true on empty scheduler")
+	}
+	if s.RemoveAllUpdateOK() {
+		t.Error("RemoveAllUpdateOK() returned true on empty scheduler")
+	}
+	if tm := s.Now(); tm != NanoAfterZero {
+		t.Errorf("Expected time %v; got %v", NanoAfterZero, tm)
+	}
+
+	t1, t2 := NanoAfterZero.Add(1), NanoAfterZero.Add(2)
+	s.Schedule(nil, t1)
+	s.Schedule(nil, t2)
+	s.Schedule(nil, t2)
+	if !s.RemoveNextUpdateOK() {
+		t.Error("RemoveNextUpdateOK() returned false on non-empty scheduler")
+	}
+	if tm := s.Now(); tm != t1 {
+		t.Errorf("Expected time %v; got %v", t1, tm)
+	}
+	if !s.RemoveAllUpdateOK() {
+		t.Error("RemoveAllUpdateOK() returned false on non-empty scheduler")
+	}
+	if tm := s.Now(); tm != t2 {
+		t.Errorf("Expected time %v; got %v", t2, tm)
+	}
+	if !s.Empty() {
+		t.Error("Scheduler should be empty")
+	}
+
+	// Corrupt the heap with events in
+	// the past; they are removed, but
+	// the clock does not move back.
+	s.heap.push(event{time: t1})
+	s.heap.push(event{time: t1})
+	if !s.RemoveNextUpdateOK() {
+		t.Error("RemoveNextUpdateOK() returned false with event in the past")
+	}
+	if !s.RemoveAllUpdateOK() {
+		t.Error("RemoveAllUpdateOK() returned false with event in the past")
+	}
+	if tm := s.Now(); tm != t2 {
+		t.Errorf("Expected time %v; got %v", t2, tm)
+	}
+	if !s.Empty() {
+		t.Error("Scheduler should be empty")
+	}
+}
+
+func TestCallNextIfDue(t *testing.T) {
+	c := &fakeClock{}
+	s := NewScheduler()
+	if _, _, err := s.CallNextIfDue(c); err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+	f := func(tm time.Time) interface{} { return tm }
+	s.Schedule(f, NanoAfterZero)
+	v, ok, err := s.CallNextIfDue(c)
+	if v != nil || ok || err != nil {
+		t.Errorf("Expected (<nil>, false, <nil>); got (%v, %v, %v)", v, ok, err)
+	}
+	c.now = NanoAfterZero
+	v, ok, err = s.CallNextIfDue(c)
+	if v != NanoAfterZero || !ok || err != nil {
+		t.Errorf("Expected (%v, true, <nil>); got (%v, %v, %v)", NanoAfterZero, v, ok, err)
+	}
+
+	s.SetClock(c)
+	s.ScheduleOffset(f, 0)
+	if _, ok, _ := s.CallNextIfDue(nil); !ok {
+		t.Error("Expected due event to be called using the Scheduler's Clock")
+	}
+}
+
+func TestMergeSorted(t *testing.T) {
+	f := func(j int) func(tm time.Time) interface{} {
+		return func(tm time.Time) interface{} { return j }
+	}
+	a := NewSchedulerTime(NanoAfterZero)
+	b := NewScheduler()
+	b.Schedule(f(0), Zero)
+	a.Schedule(f(1), NanoAfterZero)
+	b.Schedule(f(2), NanoAfterZero)
+	b.Schedule(f(4), Zero.Add(3))
+	a.Schedule(f(3), Zero.Add(2))
+	s := MergeSorted(a, b)
+	if tm := s.Now(); tm != Zero {
+		t.Errorf("Expected time %v; got %v", Zero, tm)
+	}
+	if a.Len() != 2 || b.Len() != 3 {
+		t.Errorf("Expected inputs to be unmodified; got lengths %v, %v", a.Len(), b.Len())
+	}
+	for i := 0; i < 5; i++ {
+		if v, _ := s.CallNext(); v != i {
+			t.Errorf("Expected value %v; got %v", i, v)
+		}
+	}
+	if !s.Empty() {
+		t.Error("Scheduler should be empty")
+	}
+
+	// Priority takes precedence over
+	// which input an event came from.
+	a, b = NewScheduler(), NewScheduler()
+	a.Schedule(f(1), Zero)
+	a.SchedulePriority(f(2), Zero, -1)
+	b.SchedulePriority(f(0), Zero, 5)
+	b.Schedule(f(3), Zero.Add(1))
+	a.Schedule(f(4), Zero.Add(1).Add(time.Nanosecond))
+	s = MergeSorted(a, b)
+	for i := 0; i < 5; i++ {
+		if v, _ := s.CallNext(); v != i {
+			t.Errorf("Expected value %v; got %v", i, v)
+		}
+	}
+}
+
+func benchmarkMerge(b *testing.B, merge func(x, y *Scheduler) *Scheduler) {
+	x, y := NewScheduler(), NewScheduler()
+	for i := 0; i < 100000; i++ {
+		x.ScheduleOffset(nil, time.Duration(2*i))
+		y.ScheduleOffset(nil, time.Duration(2*i+1))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		merge(x, y)
+	}
+}
+
+func BenchmarkMergeSorted(b *testing.B) { benchmarkMerge(b, MergeSorted) }
+
+func BenchmarkMergeHeapify(b *testing.B) {
+	benchmarkMerge(b, func(x, y *Scheduler) *Scheduler {
+		s := NewScheduler()
+		s.heap.events = make([]event, 0, x.Len()+y.Len())
+		s.heap.events = append(s.heap.events, x.heap.events...)
+		for _, evt := range y.heap.events {
+			evt.seq += x.seq
+			s.heap.events = append(s.heap.events, evt)
+		}
+		s.seq = x.seq + y.seq
+		s.heap.init()
+		return s
+	})
+}
+
+func TestStarted(t *testing.T) {
+	for _, s := range []*Scheduler{NewScheduler(), NewSchedulerTime(NanoAfterZero)} {
+		tm := s.Now()
+		if s.Started() {
+			t.Error("Scheduler.Started() returned true on new scheduler")
+		}
+		s.Schedule(nil, tm)
+		s.RemoveNextUpdate()
+		if s.Started() {
+			t.Error("Scheduler.Started() returned true with unchanged clock")
+		}
+		s.Schedule(nil, tm.Add(time.Nanosecond))
+		s.RemoveNextUpdate()
+		if !s.Started() {
+			t.Error("Scheduler.Started() returned false with advanced clock")
+		}
+	}
+}
+
+func tick(s *Scheduler, tm time.Time) interface{} {
+	if s.Now() != tm {
+		return nil
+	}
+	if tm.Before(Zero.Add(3)) {
+		s.ScheduleSelf(tick, tm.Add(time.Nanosecond))
+	}
+	return tm
+}
+
+func TestScheduleSelf(t *testing.T) {
+	s := NewScheduler()
+	s.ScheduleSelf(tick, Zero)
+	for i := 0; i < 4; i++ {
+		tm := Zero.Add(time.Duration(i))
+		if v, _ := s.CallNext(); v != tm {
+			t.Errorf("Expected value %v; got %v", tm, v)
+		}
+	}
+	if !s.Empty() {
+		t.Error("Scheduler should be empty")
+	}
+}
+
+func TestMedianApprox(t *testing.T) {
+	s := NewScheduler()
+	if _, err := s.MedianApprox(); err != ErrEmpty {
+		t.Errorf("Expected error %v; got %v", ErrEmpty, err)
+	}
+	r := rand.New(rand.NewSource(1))
+	for n := 1; n < 50; n++ {
+		s := NewScheduler()
+		vals := make([]int, n)
+		for i := range vals {
+			vals[i] = r.Intn(20)
+			s.Schedule(nil, Zero.Add(time.Duration(vals[i])))
+		}
+		sort.Ints(vals)
+		tm := Zero.Add(time.Duration(vals[(n-1)/2]))
+		if m, err := s.MedianApprox(); m != tm || err != nil {
+			t.Errorf("Expected (%v, <nil>); got (%v, %v)", tm, m, err)
+		}
+		if p, _ := s.PeekNext(); p != Zero.Add(time.Duration(vals[0])) {
+			t.Error("MedianApprox() modified the scheduler")
+		}
+	}
+}
+
+func TestFiredAtNow(t *testing.T) {
+	s := NewScheduler()
+	f := func(tm time.Time) interface{} { return nil }
+	for i := 0; i < 3; i++ {
+		s.Schedule(f, NanoAfterZero)
+	}
+	s.Schedule(f, NanoAfterZero.Add(time.Nanosecond))
+	if n := s.FiredAtNow(); n != 0 {
+		t.Errorf("Expected %v events fired; got %v", 0, n)
+	}
+	for i := 1; i <= 3; i++ {
+		s.CallNext()
+		if n := s.FiredAtNow(); n != i {
+			t.Errorf("Expected %v events fired; got %v", i, n)
+		}
+	}
+	s.CallNext()
+	if n := s.FiredAtNow(); n != 1 {
+		t.Errorf("Expected %v events fired; got %v", 1, n)
+	}
+
+	for i := 0; i < 4; i++ {
+		s.ScheduleOffset(f, time.Nanosecond)
+	}
+	s.CallAllAt()
+	if n := s.FiredAtNow(); n != 4 {
+		t.Errorf("Expected %v events fired; got %v", 4, n)
+	}
+	s.ScheduleOffset(f, time.Nanosecond)
+	s.RemoveNextUpdate()
+	if n := s.FiredAtNow(); n != 0 {
+		t.Errorf("Expected %v events fired; got %v", 0, n)
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	f := func(j int) func(tm time.Time) interface{} {
+		return func(tm time.Time) interface{} { return j }
+	}
+	s := NewSchedulerTime(NanoAfterZero)
+	s.Schedule(f(2), NanoAfterZero)
+	s.SchedulePriority(f(0), NanoAfterZero, 5)
+	s.Schedule(f(1), NanoAfterZero)
+	s.SetPolicy(LIFO)
+	for _, j := range []int{1, 0, 2} {
+		if v, _ := s.CallNext(); v != j {
+			t.Errorf("Expected value %v; got %v", j, v)
+		}
+	}
+	if tm := s.Now(); tm != NanoAfterZero {
+		t.Errorf("Expected time %v; got %v", NanoAfterZero, tm)
+	}
+}
+
+func TestEvictLatestOrder(t *testing.T) {
+	// Among events at the latest time,
+	// EvictLatest must evict the one which
+	// would be called last.
+	f := func(j int) func(tm time.Time) interface{} {
+		return func(tm time.Time) interface{} { return j }
+	}
+	t1, t2 := Zero.Add(1), Zero.Add(2)
+
+	s := NewSchedulerBoundedEvict(3, EvictLatest)
+	s.Schedule(f(0), t1)
+	s.SchedulePriority(f(1), t2, 5)
+	s.SchedulePriority(f(2), t2, 0)
+	s.ScheduleEvict(f(3), t1)
+	for _, j := range []int{0, 3, 1} {
+		if v, _ := s.CallNext(); v != j {
+			t.Errorf("Priority: expected value %v; got %v", j, v)
+		}
+	}
+
+	s = NewSchedulerBoundedEvict(3, EvictLatest)
+	s.SetPolicy(LIFO)
+	s.Schedule(f(0), t1)
+	s.Schedule(f(1), t2)
+	s.Schedule(f(2), t2)
+	s.ScheduleEvict(f(3), t1)
+	for _, j := range []int{3, 0, 2} {
+		if v, _ := s.CallNext(); v != j {
+			t.Errorf("LIFO: expected value %v; got %v", j, v)
+		}
+	}
+
+	// A new event at the latest time which
+	// would be called before the pending
+	// event there evicts it.
+	s = NewSchedulerBoundedEvict(1, EvictLatest)
+	s.Schedule(f(0), t2)
+	if _, ok, err := s.ScheduleEvict(f(1), t2); ok || err != ErrFull {
+		t.Errorf("Expected (false, %v); got (%v, %v)", ErrFull, ok, err)
+	}
+	s.SetPolicy(LIFO)
+	if _, ok, err := s.ScheduleEvict(f(1), t2); !ok || err != nil {
+		t.Errorf("Expected (true, <nil>); got (%v, %v)", ok, err)
+	}
+}
+
+func BenchmarkHeapPushPop(b *testing.B) {
+	// Pop the earliest event and push a
+	// later one, keeping 1000 events in
+	// the heap, without going through the
+	// Scheduler.
+	r := rand.New(rand.NewSource(1))
+	var h eventHeap
+	for i := 0; i < 1000; i++ {
+		h.push(event{time: Zero.Add(time.Duration(r.Intn(1000)))})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		evt := h.pop()
+		h.push(event{time: evt.time.Add(time.Duration(r.Intn(1000)))})
+	}
+}
+
+func TestScheduleTimesGrowthHint(t *testing.T) {
+	s := NewScheduler()
+	s.SetGrowthHint(10)
+	s.ScheduleTimes(nil, make([]time.Time, 25))
+	if c := cap(s.heap.events); c != 30 {
+		t.Errorf("Expected capacity %v; got %v", 30, c)
+	}
+
+	s = NewSchedulerBoundedEvict(2, EvictNewest)
+	s.Schedule(nil, Zero)
+	if err := s.ScheduleTimes(nil, []time.Time{Zero, Zero}); err != ErrFull {
+		t.Errorf("Expected error %v; got %v", ErrFull, err)
+	}
+	if l := s.Len(); l != 1 {
+		t.Errorf("Expected length %v; got %v", 1, l)
+	}
+}
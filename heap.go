@@ -0,0 +1,142 @@
+// Copyright 2013 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsched
+
+// eventHeap is a binary min-heap of
+// events ordered by time, and then
+// according to policy. It operates
+// on the slice directly rather than
+// through container/heap so that events
+// are never converted to interface{}.
+type eventHeap struct {
+	events []event
+	policy EqualTimePolicy
+}
+
+func (e *eventHeap) Len() int { return len(e.events) }
+
+func (e *eventHeap) Less(i, j int) bool {
+	return e.less(&e.events[i], &e.events[j])
+}
+
+// less reports whether a would be
+// called before b.
+func (e *eventHeap) less(a, b *event) bool {
+	// Use Before and Equal, as the rest
+	// of the package does, so that heap
+	// order agrees with monotonic clock
+	// readings when times have them.
+	if a.time.Before(b.time) {
+		return true
+	}
+	if !a.time.Equal(b.time) {
+		return false
+	}
+	switch e.policy {
+	case FIFO:
+		return a.seq < b.seq
+	case LIFO:
+		return a.seq > b.seq
+	}
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.seq < b.seq
+}
+
+func (e *eventHeap) swap(i, j int) {
+	e.events[i], e.events[j] = e.events[j], e.events[i]
+}
+
+// push adds evt to the heap.
+func (e *eventHeap) push(evt event) {
+	e.events = append(e.events, evt)
+	e.up(len(e.events) - 1)
+}
+
+// pop removes and returns the minimum
+// event. The heap must not be empty.
+func (e *eventHeap) pop() event {
+	return e.remove(0)
+}
+
+// remove removes and returns the
+// event at index i.
+func (e *eventHeap) remove(i int) event {
+	old := e.events
+	n := len(old) - 1
+	evt := old[i]
+	old[i] = old[n]
+	// Clear the slot so that the callback
+	// can be garbage collected.
+	old[n] = event{}
+	e.events = old[:n]
+	if i < n && !e.down(i) {
+		e.up(i)
+	}
+	return evt
+}
+
+// init establishes the heap
+// invariant over the whole slice.
+func (e *eventHeap) init() {
+	for i := len(e.events)/2 - 1; i >= 0; i-- {
+		e.down(i)
+	}
+}
+
+// filter removes every event for
+// which keep returns false, and
+// returns the number removed.
+func (e *eventHeap) filter(keep func(evt event) bool) int {
+	old := e.events
+	h := old[:0]
+	for _, evt := range old {
+		if keep(evt) {
+			h = append(h, evt)
+		}
+	}
+	// Clear the vacated slots so that the
+	// callbacks can be garbage collected.
+	for i := len(h); i < len(old); i++ {
+		old[i] = event{}
+	}
+	e.events = h
+	e.init()
+	return len(old) - len(h)
+}
+
+func (e *eventHeap) up(j int) {
+	for j > 0 {
+		i := (j - 1) / 2 // parent
+		if !e.Less(j, i) {
+			break
+		}
+		e.swap(i, j)
+		j = i
+	}
+}
+
+// down reports whether the
+// event at i was moved.
+func (e *eventHeap) down(i0 int) bool {
+	i := i0
+	n := len(e.events)
+	for {
+		j := 2*i + 1 // left child
+		if j >= n || j < 0 {
+			break
+		}
+		if r := j + 1; r < n && e.Less(r, j) {
+			j = r
+		}
+		if !e.Less(j, i) {
+			break
+		}
+		e.swap(i, j)
+		i = j
+	}
+	return i > i0
+}
@@ -0,0 +1,89 @@
+// Copyright 2013 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsched
+
+import (
+	"time"
+)
+
+type snapshot struct {
+	events []event
+	now    time.Time
+	seq    uint64
+}
+
+// History is a stack of snapshots of
+// a Scheduler's events and internal
+// clock, which allows a simulation
+// to be stepped backward.
+//
+// Each snapshot holds a copy of
+// every event scheduled at the time
+// it was taken, so memory use grows
+// with the number of events times
+// the number of snapshots. To bound
+// this, a History holds at most a
+// fixed number of snapshots; once
+// full, pushing a new snapshot
+// discards the oldest one.
+//
+// Note that callbacks are not copied,
+// so any state they refer to is
+// not restored by Undo.
+type History struct {
+	depth int
+	snaps []snapshot
+}
+
+// Returns a new History which holds
+// at most depth snapshots. If depth
+// <= 0, the History is unbounded.
+func NewHistory(depth int) *History {
+	return &History{depth: depth}
+}
+
+// Returns the number of snapshots
+// in h.
+func (h *History) Len() int {
+	return len(h.snaps)
+}
+
+// Take a snapshot of s and push
+// it onto h, discarding the oldest
+// snapshot if h is full.
+func (h *History) Push(s *Scheduler) {
+	events := make([]event, len(s.heap.events))
+	copy(events, s.heap.events)
+	if h.depth > 0 && len(h.snaps) >= h.depth {
+		h.snaps[0] = snapshot{}
+		h.snaps = h.snaps[1:]
+	}
+	h.snaps = append(h.snaps, snapshot{events, s.now, s.seq})
+}
+
+// Pop the most recent snapshot from
+// h, and restore s's events and
+// internal clock from it.
+//
+// Undo is the only way in which the
+// internal clock can move backward.
+//
+// Returns ErrEmpty if h has no
+// snapshots, in which case s is
+// not modified.
+func (h *History) Undo(s *Scheduler) error {
+	if len(h.snaps) == 0 {
+		return ErrEmpty
+	}
+	n := len(h.snaps) - 1
+	snap := h.snaps[n]
+	h.snaps[n] = snapshot{}
+	h.snaps = h.snaps[:n]
+	s.heap.events = snap.events
+	s.now = snap.now
+	s.seq = snap.seq
+	s.fired = 0
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2013 The Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fsched
+
+// Returns a new Scheduler holding all
+// of the events scheduled in a and b.
+// a and b are not modified. The events
+// of each are extracted in order, and
+// the two sequences are merged. The
+// merge is linear, but extracting the
+// events in order takes O(n log n)
+// time for n events.
+//
+// The result orders events scheduled
+// for the same time by descending
+// priority, then with events from a
+// before events from b, and finally
+// in the order in which they were
+// scheduled in a or b, regardless of
+// a's and b's EqualTimePolicy.
+//
+// The internal clock of the result is
+// set to the earlier of a.Now() and
+// b.Now(), so that no event is in the
+// past. The result has the default
+// settings of NewScheduler.
+func MergeSorted(a, b *Scheduler) *Scheduler {
+	now := a.now
+	if b.now.Before(now) {
+		now = b.now
+	}
+	s := NewSchedulerTime(now)
+	ae, be := a.sortedPolicy(s.heap.policy), b.sortedPolicy(s.heap.policy)
+	// Renumber the events so that ties
+	// go to a, and within each input to
+	// the order in which it would call
+	// them.
+	for i := range ae {
+		s.seq++
+		ae[i].seq = s.seq
+	}
+	for i := range be {
+		s.seq++
+		be[i].seq = s.seq
+	}
+	events := make([]event, 0, len(ae)+len(be))
+	for len(ae) > 0 && len(be) > 0 {
+		if s.heap.less(&be[0], &ae[0]) {
+			events = append(events, be[0])
+			be = be[1:]
+		} else {
+			events = append(events, ae[0])
+			ae = ae[1:]
+		}
+	}
+	events = append(events, ae...)
+	events = append(events, be...)
+	// A sorted slice is already a heap.
+	s.heap.events = events
+	return s
+}